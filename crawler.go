@@ -0,0 +1,111 @@
+// crawler.go
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/time/rate"
+)
+
+// Classifications for the primary OIDC probe, beyond the original
+// hasOIDC/timedOut split. They let oidcfinder tell a dead domain from a
+// domain that is merely gated, misconfigured, or rate-limiting us.
+const (
+	classOIDC         = "oidc"
+	classInvalid      = "invalid"
+	classNone         = "none"
+	classNonJSON      = "non_json"
+	classAuthRequired = "auth_required"
+	classDNSError     = "dns_nxdomain"
+	classTLSError     = "tls_error"
+	classHTTPError    = "http_error"
+	classTimeout      = "timeout"
+)
+
+// isRetryable reports whether a classification represents a transient
+// condition worth retrying with backoff, rather than a terminal result.
+func isRetryable(classification string) bool {
+	switch classification {
+	case classTimeout, classHTTPError:
+		return true
+	default:
+		return false
+	}
+}
+
+const (
+	backoffBase = time.Second
+	backoffMax  = 5 * time.Minute
+)
+
+// backoffDuration computes an exponential backoff with full jitter for
+// the given attempt number (1-indexed).
+func backoffDuration(attempt int) time.Duration {
+	d := backoffBase
+	for i := 1; i < attempt && d < backoffMax; i++ {
+		d *= 2
+	}
+	if d > backoffMax {
+		d = backoffMax
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// rateLimiters enforces a global request rate plus a per-registrable-
+// domain rate, so a crawl stays polite to any single host regardless of
+// how many subdomains of it are queued.
+type rateLimiters struct {
+	global *rate.Limiter
+
+	mu      sync.Mutex
+	perHost map[string]*rate.Limiter
+	hostQPS float64
+}
+
+// newRateLimiters builds a limiter set. globalQPS <= 0 disables the
+// global cap; hostQPS <= 0 disables per-host throttling entirely.
+func newRateLimiters(globalQPS, hostQPS float64) *rateLimiters {
+	r := &rateLimiters{perHost: make(map[string]*rate.Limiter), hostQPS: hostQPS}
+	if globalQPS > 0 {
+		r.global = rate.NewLimiter(rate.Limit(globalQPS), 1)
+	}
+	return r
+}
+
+// wait blocks until both the global and per-host rate limits allow a
+// request to domain to proceed.
+func (r *rateLimiters) wait(ctx context.Context, domain string) error {
+	if r.global != nil {
+		if err := r.global.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if r.hostQPS <= 0 {
+		return nil
+	}
+
+	host := registrableDomain(domain)
+	r.mu.Lock()
+	limiter, ok := r.perHost[host]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(r.hostQPS), 1)
+		r.perHost[host] = limiter
+	}
+	r.mu.Unlock()
+
+	return limiter.Wait(ctx)
+}
+
+// registrableDomain returns the eTLD+1 of domain (e.g. "a.b.example.co.uk"
+// -> "example.co.uk"), falling back to domain itself for anything
+// publicsuffix can't parse (bare IPs, single-label names, etc.).
+func registrableDomain(domain string) string {
+	if etldPlusOne, err := publicsuffix.EffectiveTLDPlusOne(domain); err == nil {
+		return etldPlusOne
+	}
+	return domain
+}
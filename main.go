@@ -4,31 +4,114 @@ package main
 import (
 	"bufio"
 	"context"
-	"database/sql"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/UnitVectorY-Labs/oidcfinder/store"
 )
 
 type domainResult struct {
-	domain   string
-	hasOIDC  bool
-	oidcURL  string
-	timedOut bool
+	domain         string
+	hasOIDC        bool
+	oidcURL        string
+	timedOut       bool
+	invalidReason  string
+	metadata       *oidcMetadata
+	classification string
+	// retrying is set by worker when the domain was requeued via the
+	// attempts table rather than persisted as terminal; crawlDomains'
+	// logging uses it to tell "queued for retry" apart from "gave up
+	// after exhausting retries" for the same classification.
+	retrying     bool
+	attemptCount int
+}
+
+// oidcMetadata captures the subset of the OIDC Discovery 1.0 / RFC 8414
+// document that oidcfinder validates and persists.
+type oidcMetadata struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	ScopesSupported                  []string `json:"scopes_supported"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	GrantTypesSupported              []string `json:"grant_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+
+	rawJSON string
+}
+
+// validate checks that the document carries the fields required by the
+// OIDC Discovery / RFC 8414 spec and that issuer matches the URL that was
+// requested (trailing slash ignored). It returns a human-readable reason
+// on failure so the caller can persist why a domain was rejected.
+func (m *oidcMetadata) validate(requestedURL string) error {
+	switch {
+	case m.Issuer == "":
+		return fmt.Errorf("missing issuer")
+	case m.AuthorizationEndpoint == "":
+		return fmt.Errorf("missing authorization_endpoint")
+	case m.TokenEndpoint == "":
+		return fmt.Errorf("missing token_endpoint")
+	case m.JWKSURI == "":
+		return fmt.Errorf("missing jwks_uri")
+	case len(m.ResponseTypesSupported) == 0:
+		return fmt.Errorf("missing response_types_supported")
+	case len(m.SubjectTypesSupported) == 0:
+		return fmt.Errorf("missing subject_types_supported")
+	case len(m.IDTokenSigningAlgValuesSupported) == 0:
+		return fmt.Errorf("missing id_token_signing_alg_values_supported")
+	}
+
+	issuer := strings.TrimSuffix(m.Issuer, "/")
+	wantIssuer := strings.TrimSuffix(strings.TrimSuffix(requestedURL, "/.well-known/openid-configuration"), "/")
+	if issuer != wantIssuer {
+		return fmt.Errorf("issuer %q does not match requested URL %q", issuer, wantIssuer)
+	}
+	return nil
+}
+
+// toStoreMetadata converts a validated document into the shape the store
+// package persists.
+func (m *oidcMetadata) toStoreMetadata(domain string) *store.Metadata {
+	return &store.Metadata{
+		Domain:                 domain,
+		Issuer:                 m.Issuer,
+		AuthorizationEndpoint:  m.AuthorizationEndpoint,
+		TokenEndpoint:          m.TokenEndpoint,
+		JWKSURI:                m.JWKSURI,
+		RawJSON:                m.rawJSON,
+		ScopesSupported:        m.ScopesSupported,
+		ResponseTypesSupported: m.ResponseTypesSupported,
+		GrantTypesSupported:    m.GrantTypesSupported,
+		IDTokenSigningAlgs:     m.IDTokenSigningAlgValuesSupported,
+	}
 }
 
 func main() {
 	// Flags
-	dbPath := flag.String("db", "domains.db", "SQLite database file")
+	dbDriver := flag.String("db-driver", "sqlite3", "Database driver: sqlite3, postgres, or mysql")
+	dbDSN := flag.String("db-dsn", "", "Database DSN (defaults to the -db SQLite file when empty)")
+	dbPath := flag.String("db", "domains.db", "SQLite database file (used when -db-dsn is empty)")
 	listFlag := flag.Bool("list", false, "List valid and invalid domains")
+	supportsGrant := flag.String("supports-grant", "", "With -list, only show domains whose OIDC metadata advertises this grant_types_supported value")
+	supportsAlg := flag.String("alg", "", "With -list, only show domains whose OIDC metadata advertises this id_token_signing_alg_values_supported value")
 	fileFlag := flag.String("file", "", "Path to file with domains to test (one per line)")
+	sourceFlag := flag.String("source", "", "Populate the work queue from an external domain source instead of -file: crtsh, commoncrawl, cloudflare, ovh, godaddy, ctlog")
+	queryFlag := flag.String("query", "", "Argument passed to -source (organization/keyword for crtsh, domain for commoncrawl, log URL for ctlog; unused for cloudflare, ovh, godaddy)")
 	addValid := flag.String("add-valid", "", "Add domain to valid list")
 	addInvalid := flag.String("add-invalid", "", "Add domain to invalid list")
 	rmValid := flag.String("remove-valid", "", "Remove domain from valid list")
@@ -38,8 +121,28 @@ func main() {
 	outFlag := flag.String("out", "", "Output file to append OIDC endpoint URLs (optional)")
 	parallelFlag := flag.Int("parallel", 1, "Number of parallel crawls to perform (default: 1)")
 	timeoutFlag := flag.Int("timeout", 30, "Timeout in seconds for HTTP requests (default: 30)")
+	probesFlag := flag.String("probes", "oidc", "Comma-separated discovery strategies to run: oidc,oauth2,saml,webfinger,keycloak,adfs,okta")
+	qpsFlag := flag.Float64("qps", 0, "Global max requests/second across all workers (default: 0, unlimited)")
+	hostQPSFlag := flag.Float64("host-qps", 1, "Max requests/second per registrable domain (default: 1)")
+	maxRetriesFlag := flag.Int("max-retries", 3, "Max retry attempts for a domain before giving up (default: 3)")
+	metricsAddrFlag := flag.String("metrics-addr", "", "Optional host:port to serve Prometheus metrics on /metrics (default: disabled)")
+	logFormatFlag := flag.String("log-format", "text", "Log output format: text or json")
 	flag.Parse()
 
+	switch *logFormatFlag {
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid -log-format %q, want text or json\n", *logFormatFlag)
+		os.Exit(1)
+	}
+
+	if *metricsAddrFlag != "" {
+		startMetricsServer(*metricsAddrFlag)
+	}
+
 	// Ensure exactly one action is specified
 	actions := 0
 	if *listFlag {
@@ -48,6 +151,9 @@ func main() {
 	if *fileFlag != "" {
 		actions++
 	}
+	if *sourceFlag != "" {
+		actions++
+	}
 	if *addValid != "" {
 		actions++
 	}
@@ -69,30 +175,32 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Open SQLite DB
-	db, err := sql.Open("sqlite3", *dbPath)
+	dsn := *dbDSN
+	if dsn == "" {
+		dsn = *dbPath
+	}
+	db, err := store.New(*dbDriver, dsn)
 	if err != nil {
-		log.Fatalf("Failed to open database: %v", err)
+		slog.Error("failed to open database", "error", err)
+		os.Exit(1)
 	}
 	defer db.Close()
 
-	// Create table if not exists
-	_, err = db.Exec(`
-        CREATE TABLE IF NOT EXISTS domains (
-            name TEXT PRIMARY KEY,
-            has_oidc BOOLEAN NOT NULL,
-            tested_at DATETIME DEFAULT CURRENT_TIMESTAMP
-        );
-    `)
+	probers, err := selectProbers(*probesFlag)
 	if err != nil {
-		log.Fatalf("Failed to create table: %v", err)
+		slog.Error("invalid -probes", "error", err)
+		os.Exit(1)
 	}
 
+	limiters := newRateLimiters(*qpsFlag, *hostQPSFlag)
+
 	switch {
 	case *listFlag:
-		listDomains(db)
+		listDomains(db, *supportsGrant, *supportsAlg)
 	case *fileFlag != "":
-		processFile(db, *fileFlag, *prefixFlag, *outFlag, *parallelFlag, *timeoutFlag)
+		processFile(db, *fileFlag, *prefixFlag, *outFlag, *parallelFlag, *timeoutFlag, probers, limiters, *maxRetriesFlag)
+	case *sourceFlag != "":
+		processSource(db, *sourceFlag, *queryFlag, *outFlag, *parallelFlag, *timeoutFlag, probers, limiters, *maxRetriesFlag)
 	case *addValid != "":
 		addDomain(db, strings.TrimSpace(*addValid), true)
 	case *addInvalid != "":
@@ -106,36 +214,83 @@ func main() {
 	}
 }
 
-func listDomains(db *sql.DB) {
+// fetchAndCacheJWKS fetches the JWKS document referenced by jwksURI and
+// caches it, best-effort. Failures are logged but never fatal since the
+// JWKS is supplementary to the discovery document itself.
+func fetchAndCacheJWKS(ctx context.Context, db store.Store, domain, jwksURI string, timeoutSecs int) {
+	if jwksURI == "" {
+		return
+	}
+	client := &http.Client{Timeout: time.Duration(timeoutSecs) * time.Second}
+	resp, err := client.Get(jwksURI)
+	if err != nil {
+		slog.Warn("failed to fetch JWKS", "domain", domain, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		slog.Warn("unexpected status fetching JWKS", "domain", domain, "status", resp.StatusCode)
+		return
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		slog.Warn("failed to read JWKS", "domain", domain, "error", err)
+		return
+	}
+
+	if err := db.CacheJWKS(ctx, domain, string(body)); err != nil {
+		slog.Warn("failed to cache JWKS", "domain", domain, "error", err)
+		dbWriteErrors.Inc()
+	}
+}
+
+func listDomains(db store.Store, supportsGrant, supportsAlg string) {
+	ctx := context.Background()
+
+	if supportsGrant != "" || supportsAlg != "" {
+		domains, err := db.ListByCapability(ctx, supportsGrant, supportsAlg)
+		if err != nil {
+			slog.Error("query failed", "error", err)
+			os.Exit(1)
+		}
+		for _, d := range domains {
+			fmt.Println(" -", d.Name)
+		}
+		return
+	}
+
 	fmt.Println("Valid domains:")
-	rows, err := db.Query(`SELECT name FROM domains WHERE has_oidc = 1 ORDER BY name`)
+	valid, err := db.List(ctx, true)
 	if err != nil {
-		log.Fatalf("Query failed: %v", err)
+		slog.Error("query failed", "error", err)
+		os.Exit(1)
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var name string
-		rows.Scan(&name)
-		fmt.Println(" -", name)
+	for _, d := range valid {
+		fmt.Println(" -", d.Name)
 	}
 
 	fmt.Println("\nInvalid domains:")
-	rows, err = db.Query(`SELECT name FROM domains WHERE has_oidc = 0 ORDER BY name`)
+	invalid, err := db.List(ctx, false)
 	if err != nil {
-		log.Fatalf("Query failed: %v", err)
+		slog.Error("query failed", "error", err)
+		os.Exit(1)
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var name string
-		rows.Scan(&name)
-		fmt.Println(" -", name)
+	for _, d := range invalid {
+		if d.InvalidReason != "" {
+			fmt.Printf(" - %s (%s)\n", d.Name, d.InvalidReason)
+		} else {
+			fmt.Println(" -", d.Name)
+		}
 	}
 }
 
-func processFile(db *sql.DB, path string, prefix string, outFile string, parallel int, timeoutSecs int) {
+func processFile(db store.Store, path string, prefix string, outFile string, parallel int, timeoutSecs int, probers []Prober, limiters *rateLimiters, maxRetries int) {
 	f, err := os.Open(path)
 	if err != nil {
-		log.Fatalf("Failed to open file: %v", err)
+		slog.Error("failed to open file", "error", err)
+		os.Exit(1)
 	}
 	defer f.Close()
 
@@ -153,27 +308,73 @@ func processFile(db *sql.DB, path string, prefix string, outFile string, paralle
 		domains = append(domains, domain)
 	}
 	if err := scanner.Err(); err != nil {
-		log.Fatalf("Error reading file: %v", err)
+		slog.Error("error reading file", "error", err)
+		os.Exit(1)
 	}
 
-	// Create channels for work distribution
-	domainChan := make(chan string, len(domains))
-	resultChan := make(chan domainResult, len(domains))
-
 	// Populate domain channel
+	domainChan := make(chan string, len(domains))
 	for _, domain := range domains {
 		domainChan <- domain
 	}
 	close(domainChan)
 
-	// Create worker pool
+	crawlDomains(db, domainChan, outFile, parallel, timeoutSecs, probers, limiters, maxRetries)
+}
+
+// processSource populates the work queue from an external DomainSource
+// instead of a flat file, skipping any domain already tested.
+func processSource(db store.Store, sourceName, query, outFile string, parallel, timeoutSecs int, probers []Prober, limiters *rateLimiters, maxRetries int) {
+	source, err := newDomainSource(sourceName)
+	if err != nil {
+		slog.Error("invalid -source", "error", err)
+		os.Exit(1)
+	}
+
+	domainChan := make(chan string, 256)
+	go func() {
+		defer close(domainChan)
+		ctx := context.Background()
+		seen := make(map[string]bool)
+
+		err := source.Stream(ctx, query, func(domain string) {
+			domain = strings.ToLower(strings.TrimSpace(domain))
+			if domain == "" || seen[domain] {
+				return
+			}
+			seen[domain] = true
+
+			if existing, err := db.Get(ctx, domain); err != nil {
+				slog.Warn("failed to look up domain", "domain", domain, "error", err)
+			} else if existing != nil {
+				return
+			}
+			domainChan <- domain
+		})
+		if err != nil {
+			slog.Error("source failed", "source", sourceName, "error", err)
+		}
+	}()
+
+	crawlDomains(db, domainChan, outFile, parallel, timeoutSecs, probers, limiters, maxRetries)
+}
+
+// crawlDomains runs the worker pool over domainChan until it is closed
+// and drained, printing a classification line per domain as results
+// arrive. limiters paces outbound requests and maxRetries bounds how
+// many times a transient failure is retried (with backoff, via the
+// attempts table) before it is persisted as terminal.
+func crawlDomains(db store.Store, domainChan <-chan string, outFile string, parallel, timeoutSecs int, probers []Prober, limiters *rateLimiters, maxRetries int) {
+	resultChan := make(chan domainResult, parallel)
+
+	// Serialization of writes, where a driver needs it, lives inside the
+	// store implementation, not here.
 	var wg sync.WaitGroup
-	dbMutex := &sync.Mutex{}  // Mutex for database operations
 	outMutex := &sync.Mutex{} // Mutex for output file operations
 
 	for i := 0; i < parallel; i++ {
 		wg.Add(1)
-		go worker(domainChan, resultChan, db, dbMutex, outFile, outMutex, timeoutSecs, &wg)
+		go worker(domainChan, resultChan, db, outFile, outMutex, timeoutSecs, probers, limiters, maxRetries, &wg)
 	}
 
 	// Close result channel when all workers are done
@@ -184,76 +385,182 @@ func processFile(db *sql.DB, path string, prefix string, outFile string, paralle
 
 	// Process results
 	for result := range resultChan {
-		if result.timedOut {
-			fmt.Printf("%s: request timed out (skipped) ⏰\n", result.domain)
-		} else if result.hasOIDC {
-			fmt.Printf("%s: OIDC endpoint found ✅\n", result.domain)
-		} else {
-			fmt.Printf("%s: no OIDC endpoint ❌\n", result.domain)
+		domainsProcessed.WithLabelValues(resultLabel(result.classification, result.hasOIDC)).Inc()
+		switch {
+		case result.hasOIDC:
+			slog.Info("OIDC endpoint found", "domain", result.domain)
+		case result.retrying:
+			slog.Info("transient failure, queued for retry", "domain", result.domain, "classification", result.classification, "reason", result.invalidReason, "attempt", result.attemptCount)
+		case result.classification == classTimeout:
+			slog.Info("domain timed out, gave up retrying", "domain", result.domain)
+		case isRetryable(result.classification):
+			slog.Info("transient failure, gave up retrying", "domain", result.domain, "classification", result.classification, "reason", result.invalidReason)
+		case result.invalidReason != "":
+			slog.Info("invalid OIDC document", "domain", result.domain, "reason", result.invalidReason)
+		default:
+			slog.Info("no OIDC endpoint", "domain", result.domain)
 		}
 	}
 }
 
-func worker(domainChan <-chan string, resultChan chan<- domainResult, db *sql.DB, dbMutex *sync.Mutex, outFile string, outMutex *sync.Mutex, timeoutSecs int, wg *sync.WaitGroup) {
+func worker(domainChan <-chan string, resultChan chan<- domainResult, db store.Store, outFile string, outMutex *sync.Mutex, timeoutSecs int, probers []Prober, limiters *rateLimiters, maxRetries int, wg *sync.WaitGroup) {
 	defer wg.Done()
+	ctx := context.Background()
+
+	runsOIDC := false
+	for _, p := range probers {
+		if p.Name() == "oidc" {
+			runsOIDC = true
+			break
+		}
+	}
 
 	for domain := range domainChan {
 		// Check if domain already exists in database
-		dbMutex.Lock()
-		var exists bool
-		err := db.QueryRow(`SELECT has_oidc FROM domains WHERE name = ?`, domain).Scan(&exists)
-		dbMutex.Unlock()
+		existing, err := db.Get(ctx, domain)
+		if err != nil {
+			slog.Warn("failed to look up domain", "domain", domain, "error", err)
+		}
+		if existing != nil {
+			slog.Info("domain already known", "domain", domain, "has_oidc", existing.HasOIDC)
+			continue
+		}
 
-		if err == nil {
-			fmt.Printf("%s: already known (has_oidc=%v)\n", domain, exists)
+		// A domain still in backoff from a previous run (or an earlier
+		// pass over the same file) is skipped for now rather than
+		// retried early; a later invocation of oidcfinder will pick it
+		// back up once next_retry_at has passed.
+		attempt, err := db.GetAttempt(ctx, domain)
+		if err != nil {
+			slog.Warn("failed to look up retry state", "domain", domain, "error", err)
+		}
+		if attempt != nil && time.Now().Before(attempt.NextRetryAt) {
+			slog.Info("retry scheduled, skipping for now", "domain", domain, "next_retry_at", attempt.NextRetryAt.Format(time.RFC3339))
 			continue
 		}
 
-		// Test OIDC with timeout
-		oidcURL, hasOIDC, timedOut := testOIDCWithTimeout(domain, timeoutSecs)
+		if limiters != nil {
+			if err := limiters.wait(ctx, domain); err != nil {
+				slog.Warn("rate limiter wait failed", "domain", domain, "error", err)
+				continue
+			}
+		}
+
+		inflight.Inc()
+
+		// The classic .well-known/openid-configuration probe keeps its
+		// dedicated path so full metadata (issuer, endpoints, JWKS) is
+		// captured; the other strategies only need a found/not-found
+		// classification, recorded in probe_results.
+		var result domainResult
+		if runsOIDC {
+			start := time.Now()
+			result = testOIDCWithTimeout(domain, timeoutSecs)
+			probeDuration.WithLabelValues("oidc").Observe(time.Since(start).Seconds())
+			if result.hasOIDC {
+				strategyFound.WithLabelValues("oidc").Inc()
+			}
+		}
+		result.domain = domain
+
+		otherOutcomes := runOtherProbes(domain, probers, timeoutSecs)
+		inflight.Dec()
+
+		attemptCount := 0
+		if attempt != nil {
+			attemptCount = attempt.AttemptCount
+		}
 
-		result := domainResult{
-			domain:   domain,
-			hasOIDC:  hasOIDC,
-			oidcURL:  oidcURL,
-			timedOut: timedOut,
+		if isRetryable(result.classification) && attemptCount < maxRetries {
+			attemptCount++
+			nextRetry := time.Now().Add(backoffDuration(attemptCount))
+			if err := db.UpsertAttempt(ctx, store.Attempt{
+				Domain:         domain,
+				Classification: result.classification,
+				LastError:      result.invalidReason,
+				AttemptCount:   attemptCount,
+				NextRetryAt:    nextRetry,
+			}); err != nil {
+				slog.Warn("failed to record attempt", "domain", domain, "error", err)
+				dbWriteErrors.Inc()
+			}
+			result.retrying = true
+			result.attemptCount = attemptCount
+			resultChan <- result
+			continue
 		}
 
-		// Only insert into database if not timed out
-		if !timedOut {
-			dbMutex.Lock()
-			_, err = db.Exec(`
-				INSERT INTO domains(name, has_oidc) VALUES(?, ?)
-				ON CONFLICT(name) DO UPDATE SET has_oidc=excluded.has_oidc, tested_at=CURRENT_TIMESTAMP
-			`, domain, hasOIDC)
-			dbMutex.Unlock()
-
-			if err != nil {
-				log.Printf("Failed to insert %s: %v", domain, err)
+		if attempt != nil {
+			if err := db.DeleteAttempt(ctx, domain); err != nil {
+				slog.Warn("failed to clear retry state", "domain", domain, "error", err)
+				dbWriteErrors.Inc()
 			}
+		}
 
-			// Write to output file if OIDC found
-			if hasOIDC && outFile != "" {
-				outMutex.Lock()
-				appendToFile(outFile, oidcURL)
-				outMutex.Unlock()
+		var meta *store.Metadata
+		if result.hasOIDC && result.metadata != nil {
+			meta = result.metadata.toStoreMetadata(domain)
+		}
+		if err := db.Upsert(ctx, store.Domain{Name: domain, HasOIDC: result.hasOIDC, InvalidReason: result.invalidReason}, meta); err != nil {
+			slog.Warn("failed to insert domain", "domain", domain, "error", err)
+			dbWriteErrors.Inc()
+		}
+		for _, o := range otherOutcomes {
+			if err := db.UpsertProbeResult(ctx, store.ProbeResult{Domain: domain, Strategy: o.strategy, Found: o.found, URL: o.url, Detail: o.detail}); err != nil {
+				slog.Warn("failed to store probe result", "strategy", o.strategy, "domain", domain, "error", err)
+				dbWriteErrors.Inc()
 			}
 		}
 
+		// Write to output file if OIDC found
+		if result.hasOIDC && outFile != "" {
+			outMutex.Lock()
+			appendToFile(outFile, result.oidcURL)
+			outMutex.Unlock()
+		}
+
+		// Best-effort JWKS fetch and cache
+		if result.hasOIDC && result.metadata != nil {
+			fetchAndCacheJWKS(ctx, db, domain, result.metadata.JWKSURI, timeoutSecs)
+		}
+
 		resultChan <- result
 	}
 }
 
-func addDomain(db *sql.DB, domain string, valid bool) {
+// runOtherProbes runs every selected strategy other than "oidc" (which
+// worker handles separately to capture full metadata) and returns their
+// outcomes for persistence.
+func runOtherProbes(domain string, probers []Prober, timeoutSecs int) []probeOutcome {
+	var outcomes []probeOutcome
+	for _, p := range probers {
+		if p.Name() == "oidc" {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSecs)*time.Second)
+		start := time.Now()
+		outcome, err := p.Probe(ctx, domain)
+		probeDuration.WithLabelValues(p.Name()).Observe(time.Since(start).Seconds())
+		cancel()
+		if err != nil && outcome.detail == "" {
+			outcome.detail = err.Error()
+		}
+		if outcome.found {
+			strategyFound.WithLabelValues(p.Name()).Inc()
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes
+}
+
+func addDomain(db store.Store, domain string, valid bool) {
 	if domain == "" {
-		log.Fatal("Domain is empty")
+		slog.Error("domain is empty")
+		os.Exit(1)
 	}
-	_, err := db.Exec(`
-        INSERT INTO domains(name, has_oidc) VALUES(?, ?)
-        ON CONFLICT(name) DO UPDATE SET has_oidc=excluded.has_oidc, tested_at=CURRENT_TIMESTAMP
-    `, domain, valid)
-	if err != nil {
-		log.Fatalf("Failed to add domain: %v", err)
+	if err := db.Upsert(context.Background(), store.Domain{Name: domain, HasOIDC: valid}, nil); err != nil {
+		slog.Error("failed to add domain", "error", err)
+		os.Exit(1)
 	}
 	status := "invalid"
 	if valid {
@@ -262,80 +569,124 @@ func addDomain(db *sql.DB, domain string, valid bool) {
 	fmt.Printf("Added %s to %s list\n", domain, status)
 }
 
-func removeDomain(db *sql.DB, domain string, valid bool) {
-	res, err := db.Exec(`
-        DELETE FROM domains WHERE name = ? AND has_oidc = ?
-    `, domain, valid)
+func removeDomain(db store.Store, domain string, valid bool) {
+	removed, err := db.Delete(context.Background(), domain, &valid)
 	if err != nil {
-		log.Fatalf("Failed to remove domain: %v", err)
+		slog.Error("failed to remove domain", "error", err)
+		os.Exit(1)
 	}
-	n, _ := res.RowsAffected()
-	if n > 0 {
+	if removed {
 		fmt.Printf("Removed %s from %s list\n", domain, map[bool]string{true: "valid", false: "invalid"}[valid])
 	} else {
 		fmt.Printf("Domain %s not found in %s list\n", domain, map[bool]string{true: "valid", false: "invalid"}[valid])
 	}
 }
 
-func removeAny(db *sql.DB, domain string) {
-	res, err := db.Exec(`DELETE FROM domains WHERE name = ?`, domain)
+func removeAny(db store.Store, domain string) {
+	removed, err := db.Delete(context.Background(), domain, nil)
 	if err != nil {
-		log.Fatalf("Failed to remove domain: %v", err)
+		slog.Error("failed to remove domain", "error", err)
+		os.Exit(1)
 	}
-	n, _ := res.RowsAffected()
-	if n > 0 {
+	if removed {
 		fmt.Printf("Removed %s from all lists\n", domain)
 	} else {
 		fmt.Printf("Domain %s not found\n", domain)
 	}
 }
 
-func testOIDCWithTimeout(domain string, timeoutSecs int) (string, bool, bool) {
-	url := fmt.Sprintf("https://%s/.well-known/openid-configuration", domain)
-
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: time.Duration(timeoutSecs) * time.Second,
-	}
-
-	// Create context with timeout
+// testOIDCWithTimeout probes a domain's .well-known/openid-configuration
+// endpoint within timeoutSecs. It is the entry point used by worker,
+// which needs the full domainResult (retry classification plus parsed
+// metadata for JWKS caching); oidcProber in probes.go shares the same fetch
+// logic via testOIDC so the two never drift apart.
+func testOIDCWithTimeout(domain string, timeoutSecs int) domainResult {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSecs)*time.Second)
 	defer cancel()
+	return testOIDC(ctx, domain)
+}
+
+// testOIDC does the actual fetch/parse/validate work, keyed off ctx's
+// deadline rather than a second, independently-tracked timeout.
+func testOIDC(ctx context.Context, domain string) domainResult {
+	url := fmt.Sprintf("https://%s/.well-known/openid-configuration", domain)
 
-	// Create request with context
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return "", false, false
+		return domainResult{}
 	}
 
-	// Execute request
-	resp, err := client.Do(req)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		// Check if it's a timeout error
 		if ctx.Err() == context.DeadlineExceeded {
-			log.Printf("Timeout testing %s", domain)
-			return "", false, true // timedOut = true
+			slog.Warn("timeout testing domain", "domain", domain)
+			return domainResult{timedOut: true, classification: classTimeout, invalidReason: "request timed out"}
 		}
-		return "", false, false
+		class, reason := classifyTransportError(err)
+		return domainResult{classification: class, invalidReason: reason}
 	}
 	defer resp.Body.Close()
 
+	switch {
+	case resp.StatusCode == 403 || (resp.StatusCode >= 300 && resp.StatusCode < 400):
+		return domainResult{classification: classAuthRequired, invalidReason: fmt.Sprintf("status %d", resp.StatusCode)}
+	case resp.StatusCode == 429 || resp.StatusCode >= 500:
+		return domainResult{classification: classHTTPError, invalidReason: fmt.Sprintf("status %d", resp.StatusCode)}
+	case resp.StatusCode != 200:
+		return domainResult{classification: classNone, invalidReason: fmt.Sprintf("status %d", resp.StatusCode)}
+	}
+
 	ct := resp.Header.Get("Content-Type")
-	if resp.StatusCode == 200 && strings.Contains(ct, "application/json") {
-		return url, true, false
+	if !strings.Contains(ct, "application/json") {
+		return domainResult{classification: classNonJSON, invalidReason: fmt.Sprintf("content-type %q", ct)}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return domainResult{classification: classHTTPError, invalidReason: fmt.Sprintf("failed to read response body: %v", err)}
+	}
+
+	var metadata oidcMetadata
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return domainResult{classification: classInvalid, invalidReason: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	metadata.rawJSON = string(body)
+
+	if err := metadata.validate(url); err != nil {
+		return domainResult{classification: classInvalid, invalidReason: err.Error()}
+	}
+
+	return domainResult{oidcURL: url, hasOIDC: true, classification: classOIDC, metadata: &metadata}
+}
+
+// classifyTransportError distinguishes DNS and TLS failures from other
+// transport-level errors, so they can be persisted with a meaningful
+// classification instead of a generic "no OIDC endpoint".
+func classifyTransportError(err error) (classification, detail string) {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return classDNSError, dnsErr.Error()
+	}
+
+	var certVerifyErr *tls.CertificateVerificationError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &certVerifyErr) || errors.As(err, &unknownAuthErr) || errors.As(err, &hostnameErr) {
+		return classTLSError, err.Error()
 	}
-	return "", false, false
+
+	return classHTTPError, err.Error()
 }
 
 func appendToFile(filename, content string) {
 	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		log.Printf("Failed to open output file %s: %v", filename, err)
+		slog.Warn("failed to open output file", "file", filename, "error", err)
 		return
 	}
 	defer f.Close()
 
 	if _, err := f.WriteString(content + "\n"); err != nil {
-		log.Printf("Failed to write to output file %s: %v", filename, err)
+		slog.Warn("failed to write output file", "file", filename, "error", err)
 	}
 }
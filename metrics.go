@@ -0,0 +1,70 @@
+// metrics.go
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics exposed on -metrics-addr for long-running crawls. Kept as
+// package-level vars, as is conventional for promauto collectors, since
+// oidcfinder only ever has one crawl running per process.
+var (
+	domainsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oidcfinder_domains_processed_total",
+		Help: "Domains that have completed a crawl attempt, labeled by outcome.",
+	}, []string{"result"})
+
+	probeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "oidcfinder_probe_duration_seconds",
+		Help:    "Time spent probing a single domain, per discovery strategy.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"strategy"})
+
+	inflight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "oidcfinder_inflight",
+		Help: "Domains currently being probed.",
+	})
+
+	dbWriteErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "oidcfinder_db_write_errors_total",
+		Help: "Writes to the store backend that returned an error.",
+	})
+
+	strategyFound = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "oidcfinder_strategy_found_total",
+		Help: "Successful discoveries per probing strategy.",
+	}, []string{"strategy"})
+)
+
+// resultLabel maps a probe outcome to the coarse "result" label used by
+// oidcfinder_domains_processed_total.
+func resultLabel(classification string, hasOIDC bool) string {
+	switch {
+	case hasOIDC:
+		return "oidc"
+	case classification == classTimeout:
+		return "timeout"
+	case classification == classDNSError, classification == classTLSError, classification == classHTTPError:
+		return "error"
+	default:
+		return "none"
+	}
+}
+
+// startMetricsServer serves Prometheus metrics on addr for the lifetime
+// of the process. It runs in the background; a failed bind is logged
+// but does not abort the crawl, since metrics are supplementary.
+func startMetricsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("metrics server stopped", "addr", addr, "error", err)
+		}
+	}()
+}
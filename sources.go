@@ -0,0 +1,423 @@
+// sources.go
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DomainSource populates a crawl's work queue from an external inventory
+// of domain names instead of a flat file. emit is called once per
+// candidate domain found; sources do not need to de-duplicate against
+// what's already in the store themselves.
+type DomainSource interface {
+	Name() string
+	Stream(ctx context.Context, query string, emit func(domain string)) error
+}
+
+// newDomainSource resolves a -source name into its implementation.
+func newDomainSource(name string) (DomainSource, error) {
+	switch name {
+	case "crtsh":
+		return crtSHSource{}, nil
+	case "commoncrawl":
+		return commonCrawlSource{}, nil
+	case "cloudflare":
+		return cloudflareSource{}, nil
+	case "ovh":
+		return ovhSource{}, nil
+	case "godaddy":
+		return godaddySource{}, nil
+	case "ctlog":
+		return ctLogSource{}, nil
+	default:
+		return nil, fmt.Errorf("unknown domain source %q", name)
+	}
+}
+
+// crtSHSource queries crt.sh's certificate transparency search for every
+// certificate whose subject or SAN matches the given organization or
+// keyword, and emits every hostname found in matching certificates.
+type crtSHSource struct{}
+
+func (crtSHSource) Name() string { return "crtsh" }
+
+func (crtSHSource) Stream(ctx context.Context, query string, emit func(string)) error {
+	url := fmt.Sprintf("https://crt.sh/?q=%s&output=json", url.QueryEscape(query))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("crt.sh returned status %d", resp.StatusCode)
+	}
+
+	var entries []struct {
+		NameValue string `json:"name_value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return fmt.Errorf("decode crt.sh response: %w", err)
+	}
+
+	for _, e := range entries {
+		for _, name := range strings.Split(e.NameValue, "\n") {
+			name = strings.TrimPrefix(strings.TrimSpace(name), "*.")
+			if name != "" {
+				emit(name)
+			}
+		}
+	}
+	return nil
+}
+
+// commonCrawlSource walks a Common Crawl CDX host index for every URL
+// under the given domain (including subdomains) and emits the hostname
+// of each match.
+type commonCrawlSource struct{}
+
+func (commonCrawlSource) Name() string { return "commoncrawl" }
+
+// commonCrawlIndex is the crawl snapshot queried; Common Crawl publishes
+// a new one roughly monthly under a name like "CC-MAIN-2024-10".
+const commonCrawlIndex = "CC-MAIN-2024-10"
+
+func (commonCrawlSource) Stream(ctx context.Context, domain string, emit func(string)) error {
+	url := fmt.Sprintf("https://index.commoncrawl.org/%s-index?url=*.%s&output=json&fl=url", commonCrawlIndex, url.QueryEscape(domain))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("common crawl index returned status %d", resp.StatusCode)
+	}
+
+	// The CDX API returns newline-delimited JSON, one record per line.
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var rec struct {
+			URL string `json:"url"`
+		}
+		if err := dec.Decode(&rec); err != nil {
+			return fmt.Errorf("decode common crawl record: %w", err)
+		}
+		if host := hostFromURL(rec.URL); host != "" {
+			emit(host)
+		}
+	}
+	return nil
+}
+
+// cloudflareSource enumerates every zone on a Cloudflare account and
+// emits its domain name. The API token is read from CLOUDFLARE_API_TOKEN
+// so it never needs to be passed on the command line.
+type cloudflareSource struct{}
+
+func (cloudflareSource) Name() string { return "cloudflare" }
+
+func (cloudflareSource) Stream(ctx context.Context, _ string, emit func(string)) error {
+	token := os.Getenv("CLOUDFLARE_API_TOKEN")
+	if token == "" {
+		return fmt.Errorf("CLOUDFLARE_API_TOKEN is not set")
+	}
+
+	page := 1
+	for {
+		url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones?page=%d&per_page=50", page)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		var body struct {
+			Result []struct {
+				Name string `json:"name"`
+			} `json:"result"`
+			ResultInfo struct {
+				Page       int `json:"page"`
+				TotalPages int `json:"total_pages"`
+			} `json:"result_info"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decode cloudflare response: %w", err)
+		}
+
+		for _, zone := range body.Result {
+			emit(zone.Name)
+		}
+
+		if body.ResultInfo.TotalPages == 0 || body.ResultInfo.Page >= body.ResultInfo.TotalPages {
+			return nil
+		}
+		page++
+	}
+}
+
+// ovhSource enumerates every domain on an OVH account and emits its
+// name. Credentials are read from OVH_APPLICATION_KEY,
+// OVH_APPLICATION_SECRET, and OVH_CONSUMER_KEY so they never need to be
+// passed on the command line; OVH_ENDPOINT selects the regional API
+// (default: the EU endpoint).
+type ovhSource struct{}
+
+func (ovhSource) Name() string { return "ovh" }
+
+func (ovhSource) Stream(ctx context.Context, _ string, emit func(string)) error {
+	appKey := os.Getenv("OVH_APPLICATION_KEY")
+	appSecret := os.Getenv("OVH_APPLICATION_SECRET")
+	consumerKey := os.Getenv("OVH_CONSUMER_KEY")
+	if appKey == "" || appSecret == "" || consumerKey == "" {
+		return fmt.Errorf("OVH_APPLICATION_KEY, OVH_APPLICATION_SECRET, and OVH_CONSUMER_KEY must all be set")
+	}
+	endpoint := os.Getenv("OVH_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://eu.api.ovh.com/1.0"
+	}
+
+	reqURL := endpoint + "/domain"
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("X-Ovh-Application", appKey)
+	req.Header.Set("X-Ovh-Consumer", consumerKey)
+	req.Header.Set("X-Ovh-Timestamp", fmt.Sprintf("%d", timestamp))
+	req.Header.Set("X-Ovh-Signature", ovhSignature(appSecret, consumerKey, "GET", reqURL, "", timestamp))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("OVH API returned status %d", resp.StatusCode)
+	}
+
+	var domains []string
+	if err := json.NewDecoder(resp.Body).Decode(&domains); err != nil {
+		return fmt.Errorf("decode OVH response: %w", err)
+	}
+	for _, d := range domains {
+		emit(d)
+	}
+	return nil
+}
+
+// ovhSignature computes the request signature OVH's API requires, per
+// https://docs.ovh.com/gb/en/api/first-steps-with-ovh-api/: "$1$" followed
+// by the hex SHA-1 of applicationSecret+consumerKey+method+url+body+timestamp.
+func ovhSignature(appSecret, consumerKey, method, url, body string, timestamp int64) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s+%s+%s+%s+%s+%d", appSecret, consumerKey, method, url, body, timestamp)
+	return "$1$" + hex.EncodeToString(h.Sum(nil))
+}
+
+// godaddySource enumerates every domain on a GoDaddy account and emits
+// its name. Credentials are read from GODADDY_API_KEY and
+// GODADDY_API_SECRET so they never need to be passed on the command
+// line.
+type godaddySource struct{}
+
+func (godaddySource) Name() string { return "godaddy" }
+
+const godaddyPageLimit = 500
+
+func (godaddySource) Stream(ctx context.Context, _ string, emit func(string)) error {
+	apiKey := os.Getenv("GODADDY_API_KEY")
+	apiSecret := os.Getenv("GODADDY_API_SECRET")
+	if apiKey == "" || apiSecret == "" {
+		return fmt.Errorf("GODADDY_API_KEY and GODADDY_API_SECRET must both be set")
+	}
+
+	marker := ""
+	for {
+		reqURL := fmt.Sprintf("https://api.godaddy.com/v1/domains?limit=%d", godaddyPageLimit)
+		if marker != "" {
+			reqURL += "&marker=" + url.QueryEscape(marker)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "sso-key "+apiKey+":"+apiSecret)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		var domains []struct {
+			Domain string `json:"domain"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&domains)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decode GoDaddy response: %w", err)
+		}
+		if len(domains) == 0 {
+			return nil
+		}
+
+		for _, d := range domains {
+			emit(d.Domain)
+		}
+		marker = domains[len(domains)-1].Domain
+
+		if len(domains) < godaddyPageLimit {
+			return nil
+		}
+	}
+}
+
+// ctLogSource reads a single Certificate Transparency log via the RFC
+// 6962 get-entries API and emits the DNS names found in each leaf
+// certificate. It reads from entry 0 up to the tree size reported by one
+// get-sth call at the start, then returns; entries appended to the log
+// after that call are not read. query is the log's base URL, e.g.
+// "https://ct.googleapis.com/logs/eu1/xenon2024". Precertificates use a
+// poison extension that prevents standard X.509 parsing; those entries
+// are skipped rather than mis-parsed.
+type ctLogSource struct{}
+
+func (ctLogSource) Name() string { return "ctlog" }
+
+const ctLogBatchSize = 256
+
+func (ctLogSource) Stream(ctx context.Context, logURL string, emit func(string)) error {
+	logURL = strings.TrimSuffix(logURL, "/")
+
+	sth, err := ctLogSTH(ctx, logURL)
+	if err != nil {
+		return fmt.Errorf("fetch STH: %w", err)
+	}
+
+	for start := int64(0); start < sth; {
+		end := start + ctLogBatchSize - 1
+		if end >= sth {
+			end = sth - 1
+		}
+
+		url := fmt.Sprintf("%s/ct/v1/get-entries?start=%d&end=%d", logURL, start, end)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		var body struct {
+			Entries []struct {
+				LeafInput string `json:"leaf_input"`
+			} `json:"entries"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decode get-entries response: %w", err)
+		}
+
+		for _, e := range body.Entries {
+			for _, name := range ctLeafDNSNames(e.LeafInput) {
+				emit(name)
+			}
+		}
+
+		// The log server is free to cap a get-entries response below the
+		// requested range (RFC 6962 §4.6); advance by what was actually
+		// returned, not the requested batch size, or the gap between the
+		// two silently drops entries. A server returning zero entries for
+		// a non-empty range would otherwise spin forever, so treat that
+		// as exhausted too.
+		if len(body.Entries) == 0 {
+			break
+		}
+		start += int64(len(body.Entries))
+	}
+	return nil
+}
+
+func ctLogSTH(ctx context.Context, logURL string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", logURL+"/ct/v1/get-sth", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var sth struct {
+		TreeSize int64 `json:"tree_size"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&sth); err != nil {
+		return 0, err
+	}
+	return sth.TreeSize, nil
+}
+
+// ctLeafDNSNames extracts DNS SAN entries from an RFC 6962 MerkleTreeLeaf
+// that wraps an ordinary (non-precert) X.509 certificate. The leaf_input
+// layout is: version(1) + leaf_type(1) + timestamp(8) + entry_type(2) +
+// [x509_entry: length(3) + DER cert] for entry_type 0.
+func ctLeafDNSNames(leafInputB64 string) []string {
+	raw, err := base64.StdEncoding.DecodeString(leafInputB64)
+	if err != nil || len(raw) < 15 {
+		return nil
+	}
+	entryType := int(raw[10])<<8 | int(raw[11])
+	if entryType != 0 { // 0 = x509_entry; 1 = precert_entry (skipped, see doc comment)
+		return nil
+	}
+	certLen := int(raw[12])<<16 | int(raw[13])<<8 | int(raw[14])
+	if 15+certLen > len(raw) {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(raw[15 : 15+certLen])
+	if err != nil {
+		return nil
+	}
+	return cert.DNSNames
+}
+
+// hostFromURL extracts the host component from a URL, dropping any port.
+func hostFromURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
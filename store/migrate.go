@@ -0,0 +1,98 @@
+package store
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations
+var migrationFS embed.FS
+
+// migrationTableDDL is the schema_migrations table statement per driver;
+// it must be applied before any versioned migration can be tracked.
+var migrationTableDDL = map[string]string{
+	"sqlite":   `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at DATETIME DEFAULT CURRENT_TIMESTAMP);`,
+	"postgres": `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at TIMESTAMPTZ NOT NULL DEFAULT now());`,
+	"mysql":    `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);`,
+}
+
+// migrate applies every migrations/<driver>/NNNN_*.sql file that hasn't
+// already been recorded in schema_migrations, in version order, so
+// schema changes roll forward safely across restarts and across drivers.
+func migrate(db *sql.DB, driver string) error {
+	ddl, ok := migrationTableDDL[driver]
+	if !ok {
+		return fmt.Errorf("no migration table DDL for driver %q", driver)
+	}
+	if _, err := db.Exec(ddl); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	applied := map[int]bool{}
+	rows, err := db.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("read schema_migrations: %w", err)
+	}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+
+	entries, err := fs.ReadDir(migrationFS, "migrations/"+driver)
+	if err != nil {
+		return fmt.Errorf("no migrations for driver %q: %w", driver, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		version, err := migrationVersion(entry.Name())
+		if err != nil {
+			return err
+		}
+		if applied[version] {
+			continue
+		}
+
+		sqlBytes, err := migrationFS.ReadFile("migrations/" + driver + "/" + entry.Name())
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(string(sqlBytes)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("apply migration %s: %w", entry.Name(), err)
+		}
+		if _, err := tx.Exec(rebind(driver, `INSERT INTO schema_migrations(version) VALUES(?)`), version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("record migration %s: %w", entry.Name(), err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrationVersion extracts the leading numeric prefix from a migration
+// filename such as "0001_init.sql".
+func migrationVersion(name string) (int, error) {
+	prefix, _, ok := strings.Cut(name, "_")
+	if !ok {
+		return 0, fmt.Errorf("migration %q missing version prefix", name)
+	}
+	return strconv.Atoi(prefix)
+}
@@ -0,0 +1,262 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// sqlStore implements Store on top of database/sql, covering SQLite,
+// PostgreSQL, and MySQL in one implementation: portable queries are
+// shared (rebound per driver where placeholder syntax differs), and
+// upserts carry one query variant per driver.
+type sqlStore struct {
+	db     *sql.DB
+	driver string
+
+	// writeMu serializes writes on drivers that don't support concurrent
+	// writers (SQLite). nil on drivers that pool natively.
+	writeMu *sync.Mutex
+}
+
+func (s *sqlStore) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if s.writeMu != nil {
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+	}
+	return s.db.ExecContext(ctx, rebind(s.driver, query), args...)
+}
+
+func (s *sqlStore) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, rebind(s.driver, query), args...)
+}
+
+func (s *sqlStore) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRowContext(ctx, rebind(s.driver, query), args...)
+}
+
+func (s *sqlStore) Close() error { return s.db.Close() }
+
+func (s *sqlStore) Get(ctx context.Context, name string) (*Domain, error) {
+	var d Domain
+	var reason sql.NullString
+	err := s.queryRow(ctx, `SELECT name, has_oidc, invalid_reason, tested_at FROM domains WHERE name = ?`, name).
+		Scan(&d.Name, &d.HasOIDC, &reason, &d.TestedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.InvalidReason = reason.String
+	return &d, nil
+}
+
+func (s *sqlStore) Upsert(ctx context.Context, d Domain, meta *Metadata) error {
+	upsert := map[string]string{
+		"sqlite3": `INSERT INTO domains(name, has_oidc, invalid_reason) VALUES(?, ?, ?)
+			ON CONFLICT(name) DO UPDATE SET has_oidc=excluded.has_oidc, invalid_reason=excluded.invalid_reason, tested_at=CURRENT_TIMESTAMP`,
+		"postgres": `INSERT INTO domains(name, has_oidc, invalid_reason) VALUES(?, ?, ?)
+			ON CONFLICT(name) DO UPDATE SET has_oidc=excluded.has_oidc, invalid_reason=excluded.invalid_reason, tested_at=now()`,
+		"mysql": `INSERT INTO domains(name, has_oidc, invalid_reason) VALUES(?, ?, ?)
+			ON DUPLICATE KEY UPDATE has_oidc=VALUES(has_oidc), invalid_reason=VALUES(invalid_reason), tested_at=CURRENT_TIMESTAMP`,
+	}
+	if _, err := s.exec(ctx, upsert[s.driver], d.Name, d.HasOIDC, d.InvalidReason); err != nil {
+		return fmt.Errorf("upsert domain: %w", err)
+	}
+
+	if meta == nil {
+		return nil
+	}
+	return s.upsertMetadata(ctx, meta)
+}
+
+func (s *sqlStore) upsertMetadata(ctx context.Context, m *Metadata) error {
+	upsert := map[string]string{
+		"sqlite3": `INSERT INTO oidc_metadata(domain, issuer, authorization_endpoint, token_endpoint, jwks_uri, raw_json)
+			VALUES(?, ?, ?, ?, ?, ?)
+			ON CONFLICT(domain) DO UPDATE SET issuer=excluded.issuer, authorization_endpoint=excluded.authorization_endpoint,
+				token_endpoint=excluded.token_endpoint, jwks_uri=excluded.jwks_uri, raw_json=excluded.raw_json, tested_at=CURRENT_TIMESTAMP`,
+		"postgres": `INSERT INTO oidc_metadata(domain, issuer, authorization_endpoint, token_endpoint, jwks_uri, raw_json)
+			VALUES(?, ?, ?, ?, ?, ?)
+			ON CONFLICT(domain) DO UPDATE SET issuer=excluded.issuer, authorization_endpoint=excluded.authorization_endpoint,
+				token_endpoint=excluded.token_endpoint, jwks_uri=excluded.jwks_uri, raw_json=excluded.raw_json, tested_at=now()`,
+		"mysql": `INSERT INTO oidc_metadata(domain, issuer, authorization_endpoint, token_endpoint, jwks_uri, raw_json)
+			VALUES(?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE issuer=VALUES(issuer), authorization_endpoint=VALUES(authorization_endpoint),
+				token_endpoint=VALUES(token_endpoint), jwks_uri=VALUES(jwks_uri), raw_json=VALUES(raw_json), tested_at=CURRENT_TIMESTAMP`,
+	}
+	if _, err := s.exec(ctx, upsert[s.driver], m.Domain, m.Issuer, m.AuthorizationEndpoint, m.TokenEndpoint, m.JWKSURI, m.RawJSON); err != nil {
+		return fmt.Errorf("upsert metadata: %w", err)
+	}
+
+	lists := []struct {
+		table  string
+		values []string
+	}{
+		{"oidc_response_types", m.ResponseTypesSupported},
+		{"oidc_grant_types", m.GrantTypesSupported},
+		{"oidc_scopes", m.ScopesSupported},
+		{"oidc_algs", m.IDTokenSigningAlgs},
+	}
+	for _, l := range lists {
+		if _, err := s.exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE domain = ?`, l.table), m.Domain); err != nil {
+			return fmt.Errorf("clear %s: %w", l.table, err)
+		}
+		for _, v := range l.values {
+			var insert string
+			switch s.driver {
+			case "sqlite3":
+				insert = "INSERT OR IGNORE INTO %s(domain, value) VALUES(?, ?)"
+			case "postgres":
+				insert = "INSERT INTO %s(domain, value) VALUES(?, ?) ON CONFLICT (domain, value) DO NOTHING"
+			default:
+				insert = "INSERT IGNORE INTO %s(domain, value) VALUES(?, ?)"
+			}
+			if _, err := s.exec(ctx, fmt.Sprintf(insert, l.table), m.Domain, v); err != nil {
+				return fmt.Errorf("insert %s: %w", l.table, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) List(ctx context.Context, hasOIDC bool) ([]Domain, error) {
+	rows, err := s.query(ctx, `SELECT name, has_oidc, invalid_reason, tested_at FROM domains WHERE has_oidc = ? ORDER BY name`, hasOIDC)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Domain
+	for rows.Next() {
+		var d Domain
+		var reason sql.NullString
+		if err := rows.Scan(&d.Name, &d.HasOIDC, &reason, &d.TestedAt); err != nil {
+			return nil, err
+		}
+		d.InvalidReason = reason.String
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) Delete(ctx context.Context, name string, hasOIDC *bool) (bool, error) {
+	match := `name = ?`
+	args := []interface{}{name}
+	if hasOIDC != nil {
+		match += ` AND has_oidc = ?`
+		args = append(args, *hasOIDC)
+	}
+	// Rows referencing the domain go first, so the foreign keys to
+	// domains(name) don't reject the delete.
+	for _, table := range []string{"oidc_metadata", "oidc_response_types", "oidc_grant_types", "oidc_scopes", "oidc_algs", "jwks_cache", "probe_results"} {
+		if _, err := s.exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE domain IN (SELECT name FROM domains WHERE %s)`, table, match), args...); err != nil {
+			return false, fmt.Errorf("clear %s: %w", table, err)
+		}
+	}
+	res, err := s.exec(ctx, `DELETE FROM domains WHERE `+match, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlStore) ListByCapability(ctx context.Context, grantType, alg string) ([]Domain, error) {
+	query := `SELECT DISTINCT d.name, d.has_oidc, d.invalid_reason, d.tested_at FROM domains d WHERE d.has_oidc = ?`
+	args := []interface{}{true}
+	if grantType != "" {
+		query += ` AND EXISTS (SELECT 1 FROM oidc_grant_types g WHERE g.domain = d.name AND g.value = ?)`
+		args = append(args, grantType)
+	}
+	if alg != "" {
+		query += ` AND EXISTS (SELECT 1 FROM oidc_algs a WHERE a.domain = d.name AND a.value = ?)`
+		args = append(args, alg)
+	}
+	query += ` ORDER BY d.name`
+
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Domain
+	for rows.Next() {
+		var d Domain
+		var reason sql.NullString
+		if err := rows.Scan(&d.Name, &d.HasOIDC, &reason, &d.TestedAt); err != nil {
+			return nil, err
+		}
+		d.InvalidReason = reason.String
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) UpsertProbeResult(ctx context.Context, r ProbeResult) error {
+	upsert := map[string]string{
+		"sqlite3": `INSERT INTO probe_results(domain, strategy, found, url, detail) VALUES(?, ?, ?, ?, ?)
+			ON CONFLICT(domain, strategy) DO UPDATE SET found=excluded.found, url=excluded.url, detail=excluded.detail, tested_at=CURRENT_TIMESTAMP`,
+		"postgres": `INSERT INTO probe_results(domain, strategy, found, url, detail) VALUES(?, ?, ?, ?, ?)
+			ON CONFLICT(domain, strategy) DO UPDATE SET found=excluded.found, url=excluded.url, detail=excluded.detail, tested_at=now()`,
+		"mysql": `INSERT INTO probe_results(domain, strategy, found, url, detail) VALUES(?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE found=VALUES(found), url=VALUES(url), detail=VALUES(detail), tested_at=CURRENT_TIMESTAMP`,
+	}
+	_, err := s.exec(ctx, upsert[s.driver], r.Domain, r.Strategy, r.Found, r.URL, r.Detail)
+	return err
+}
+
+func (s *sqlStore) GetAttempt(ctx context.Context, domain string) (*Attempt, error) {
+	var a Attempt
+	var classification, lastError sql.NullString
+	var nextRetryAt sql.NullTime
+	err := s.queryRow(ctx, `SELECT domain, classification, last_error, attempt_count, next_retry_at FROM attempts WHERE domain = ?`, domain).
+		Scan(&a.Domain, &classification, &lastError, &a.AttemptCount, &nextRetryAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.Classification = classification.String
+	a.LastError = lastError.String
+	a.NextRetryAt = nextRetryAt.Time
+	return &a, nil
+}
+
+func (s *sqlStore) UpsertAttempt(ctx context.Context, a Attempt) error {
+	upsert := map[string]string{
+		"sqlite3": `INSERT INTO attempts(domain, classification, last_error, attempt_count, next_retry_at) VALUES(?, ?, ?, ?, ?)
+			ON CONFLICT(domain) DO UPDATE SET classification=excluded.classification, last_error=excluded.last_error,
+				attempt_count=excluded.attempt_count, next_retry_at=excluded.next_retry_at`,
+		"postgres": `INSERT INTO attempts(domain, classification, last_error, attempt_count, next_retry_at) VALUES(?, ?, ?, ?, ?)
+			ON CONFLICT(domain) DO UPDATE SET classification=excluded.classification, last_error=excluded.last_error,
+				attempt_count=excluded.attempt_count, next_retry_at=excluded.next_retry_at`,
+		"mysql": `INSERT INTO attempts(domain, classification, last_error, attempt_count, next_retry_at) VALUES(?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE classification=VALUES(classification), last_error=VALUES(last_error),
+				attempt_count=VALUES(attempt_count), next_retry_at=VALUES(next_retry_at)`,
+	}
+	_, err := s.exec(ctx, upsert[s.driver], a.Domain, a.Classification, a.LastError, a.AttemptCount, a.NextRetryAt)
+	return err
+}
+
+func (s *sqlStore) DeleteAttempt(ctx context.Context, domain string) error {
+	_, err := s.exec(ctx, `DELETE FROM attempts WHERE domain = ?`, domain)
+	return err
+}
+
+func (s *sqlStore) CacheJWKS(ctx context.Context, domain, rawJSON string) error {
+	upsert := map[string]string{
+		"sqlite3": `INSERT INTO jwks_cache(domain, raw_json) VALUES(?, ?)
+			ON CONFLICT(domain) DO UPDATE SET raw_json=excluded.raw_json, fetched_at=CURRENT_TIMESTAMP`,
+		"postgres": `INSERT INTO jwks_cache(domain, raw_json) VALUES(?, ?)
+			ON CONFLICT(domain) DO UPDATE SET raw_json=excluded.raw_json, fetched_at=now()`,
+		"mysql": `INSERT INTO jwks_cache(domain, raw_json) VALUES(?, ?)
+			ON DUPLICATE KEY UPDATE raw_json=VALUES(raw_json), fetched_at=CURRENT_TIMESTAMP`,
+	}
+	_, err := s.exec(ctx, upsert[s.driver], domain, rawJSON)
+	return err
+}
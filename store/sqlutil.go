@@ -0,0 +1,26 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rebind rewrites a query written with "?" positional placeholders into
+// the syntax the given driver expects. SQLite and MySQL both accept "?"
+// natively; PostgreSQL requires "$1", "$2", ...
+func rebind(driver, query string) string {
+	if driver != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,157 @@
+// Package store persists oidcfinder's crawl results behind a
+// database-agnostic interface, so the SQLite/PostgreSQL/MySQL backends
+// share one implementation and one migration mechanism (with per-driver
+// migration files and upsert queries).
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Domain is the top-level record of a tested domain.
+type Domain struct {
+	Name          string
+	HasOIDC       bool
+	InvalidReason string
+	TestedAt      time.Time
+}
+
+// Metadata is a parsed and validated OIDC discovery document.
+type Metadata struct {
+	Domain                 string
+	Issuer                 string
+	AuthorizationEndpoint  string
+	TokenEndpoint          string
+	JWKSURI                string
+	RawJSON                string
+	ScopesSupported        []string
+	ResponseTypesSupported []string
+	GrantTypesSupported    []string
+	IDTokenSigningAlgs     []string
+}
+
+// Attempt tracks retry bookkeeping for a domain that hasn't reached a
+// terminal classification yet, so a crawl can be killed and resumed
+// without re-hitting domains that are still in backoff.
+type Attempt struct {
+	Domain         string
+	Classification string
+	LastError      string
+	AttemptCount   int
+	NextRetryAt    time.Time
+}
+
+// ProbeResult is the outcome of running one non-OIDC discovery strategy
+// (SAML, OAuth2 AS metadata, WebFinger, vendor paths, ...) against a
+// domain.
+type ProbeResult struct {
+	Domain   string
+	Strategy string
+	Found    bool
+	URL      string
+	Detail   string
+}
+
+// Store is the persistence interface implemented by each supported
+// database backend.
+type Store interface {
+	// Get returns the record for name, or nil if it hasn't been tested.
+	Get(ctx context.Context, name string) (*Domain, error)
+	// Upsert records the result of testing a domain, along with its
+	// parsed OIDC metadata when meta is non-nil.
+	Upsert(ctx context.Context, d Domain, meta *Metadata) error
+	// List returns every domain with the given validity, ordered by name.
+	List(ctx context.Context, hasOIDC bool) ([]Domain, error)
+	// Delete removes a domain. When hasOIDC is non-nil, the delete only
+	// applies if the stored record matches that validity.
+	Delete(ctx context.Context, name string, hasOIDC *bool) (bool, error)
+	// ListByCapability returns valid domains whose OIDC metadata
+	// advertises the given grant type and/or signing algorithm. Either
+	// filter may be left empty.
+	ListByCapability(ctx context.Context, grantType, alg string) ([]Domain, error)
+	// UpsertProbeResult records the outcome of a single non-OIDC
+	// discovery strategy for a domain.
+	UpsertProbeResult(ctx context.Context, r ProbeResult) error
+	// CacheJWKS stores the raw JWKS document fetched from a domain's
+	// jwks_uri.
+	CacheJWKS(ctx context.Context, domain, rawJSON string) error
+	// GetAttempt returns the retry bookkeeping for a domain, or nil if
+	// it has none (never attempted, or already resolved terminally).
+	GetAttempt(ctx context.Context, domain string) (*Attempt, error)
+	// UpsertAttempt records a non-terminal probe outcome and when to
+	// retry it next.
+	UpsertAttempt(ctx context.Context, a Attempt) error
+	// DeleteAttempt clears retry bookkeeping once a domain reaches a
+	// terminal classification.
+	DeleteAttempt(ctx context.Context, domain string) error
+	Close() error
+}
+
+// New opens a Store backed by the given driver ("sqlite3", "postgres",
+// or "mysql") and DSN, running any pending migrations before returning.
+func New(driver, dsn string) (Store, error) {
+	switch driver {
+	case "", "sqlite3", "sqlite":
+		driver = "sqlite3"
+	case "postgres", "postgresql":
+		driver = "postgres"
+	case "mysql":
+		driver = "mysql"
+	default:
+		return nil, fmt.Errorf("unknown db driver %q", driver)
+	}
+
+	if driver == "mysql" {
+		var err error
+		dsn, err = withMySQLDefaults(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("parse mysql dsn: %w", err)
+		}
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", driver, err)
+	}
+
+	migrationDriver := driver
+	if migrationDriver == "sqlite3" {
+		migrationDriver = "sqlite"
+	}
+	if err := migrate(db, migrationDriver); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	s := &sqlStore{db: db, driver: driver}
+	if driver == "sqlite3" {
+		// SQLite allows only one writer at a time; every other backend
+		// gets its concurrency from the driver's own connection pool.
+		s.writeMu = &sync.Mutex{}
+	}
+	return s, nil
+}
+
+// withMySQLDefaults forces on the two options this package requires to
+// function against MySQL: multiStatements, since migrate applies each
+// migration file as a single multi-statement Exec, and parseTime,
+// since Domain.TestedAt and Attempt.NextRetryAt are scanned directly
+// into time.Time. Both are enforced regardless of what the caller's
+// DSN specifies, since the driver silently misbehaves without them.
+func withMySQLDefaults(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	cfg.MultiStatements = true
+	cfg.ParseTime = true
+	return cfg.FormatDSN(), nil
+}